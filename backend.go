@@ -0,0 +1,45 @@
+package smtp
+
+// Backend authenticates users and hands back a Session for the rest of
+// the mail transaction.
+type Backend interface {
+	Login(username, password string) (Session, error)
+
+	// AnonymousLogin is called for a MAIL FROM received without a prior
+	// successful AUTH, letting a Backend accept unauthenticated mail
+	// MTA-style instead of requiring every client to go through a (maybe
+	// fake) AUTH round-trip first. Return an error to keep demanding
+	// authentication.
+	AnonymousLogin(state *ConnectionState) (Session, error)
+}
+
+// PasswordVerifier is implemented by a Backend that can hand back a
+// user's plaintext password, letting challenge-response mechanisms like
+// CRAM-MD5 compute their own digest to compare against the client's
+// instead of taking the client's word for it the way Login does. With a
+// Backend that doesn't implement it, CRAM-MD5 is still advertised but
+// every attempt fails with 535.
+type PasswordVerifier interface {
+	Password(username string) (password string, err error)
+}
+
+// ScramCredential is a user's stored SCRAM credential (RFC 5802 section
+// 5): salt, iteration count, StoredKey and ServerKey, never the
+// plaintext password itself.
+type ScramCredential struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// CredentialLookup is implemented by a Backend that can fetch a user's
+// ScramCredential and, once a SCRAM exchange has verified it, hand back
+// a Session for that username without ever having seen a plaintext
+// password. With a Backend that doesn't implement it,
+// SCRAM-SHA-1/SCRAM-SHA-256 are still advertised but every attempt fails
+// with 535.
+type CredentialLookup interface {
+	Credential(username, mechanism string) (*ScramCredential, error)
+	SessionForUser(username string) (Session, error)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
@@ -16,12 +17,23 @@ import (
 type Conn struct {
 	server    *Server
 	helo      string
-	User      User
+	session   Session
 	msg       *Message
 	conn      net.Conn
 	reader    *bufio.Reader
 	writer    *bufio.Writer
+	limiter   *lineLimitReader
 	nbrErrors int
+
+	// bodyType holds the BODY= parameter from the current MAIL command
+	// (e.g. "8BITMIME", "BINARYMIME"), so DATA can refuse a BINARYMIME
+	// message, which has to come by BDAT.
+	bodyType string
+	// smtputf8 records whether the current MAIL command announced
+	// SMTPUTF8, so RCPT can tell whether a non-ASCII recipient is allowed.
+	smtputf8 bool
+	// chunk tracks an in-progress BDAT transfer, nil outside of one.
+	chunk *chunkingState
 }
 
 func newConn(c net.Conn, s *Server) *Conn {
@@ -31,11 +43,23 @@ func newConn(c net.Conn, s *Server) *Conn {
 	}
 
 	sc.init()
+
+	if err := sc.applyProxyProtocol(); err != nil {
+		sc.conn.Close()
+		return nil
+	}
+
 	return sc
 }
 
 func (c *Conn) init() {
-	r := io.Reader(c.conn)
+	maxLine := c.server.MaxLineLength
+	if maxLine <= 0 {
+		maxLine = defaultMaxLineLength
+	}
+
+	c.limiter = &lineLimitReader{r: c.conn, maxLine: maxLine}
+	r := io.Reader(c.limiter)
 	w := io.Writer(c.conn)
 
 	if c.server.Debug != nil {
@@ -58,8 +82,12 @@ func (c *Conn) handle(cmd string, arg string) {
 	case "SEND", "SOML", "SAML", "EXPN", "HELP", "TURN":
 		// These commands are not implemented in any state
 		c.Write("502", fmt.Sprintf("%v command not implemented", cmd))
-	case "HELO", "EHLO":
-		c.handleGreet((cmd == "EHLO"), arg)
+	case "HELO", "EHLO", "LHLO":
+		if (cmd == "LHLO") != c.server.LMTP {
+			c.Write("500", fmt.Sprintf("%v not allowed, this server speaks %v", cmd, c.protocolName()))
+			return
+		}
+		c.handleGreet((cmd != "HELO"), arg)
 	case "MAIL":
 		c.handleMail(arg)
 	case "RCPT":
@@ -73,6 +101,8 @@ func (c *Conn) handle(cmd string, arg string) {
 		c.Write("250", "Session reset")
 	case "DATA":
 		c.handleData(arg)
+	case "BDAT":
+		c.handleBdat(arg)
 	case "QUIT":
 		c.Write("221", "Goodnight and good luck")
 		c.Close()
@@ -96,8 +126,8 @@ func (c *Conn) Server() *Server {
 }
 
 func (c *Conn) Close() error {
-	if c.User != nil {
-		c.User.Logout()
+	if c.session != nil {
+		c.session.Logout()
 	}
 
 	return c.conn.Close()
@@ -109,6 +139,80 @@ func (c *Conn) IsTLS() bool {
 	return ok
 }
 
+// protocolName names the protocol this connection speaks, for use in
+// error text when a client uses the wrong greeting verb.
+func (c *Conn) protocolName() string {
+	if c.server.LMTP {
+		return "LMTP"
+	}
+	return "SMTP"
+}
+
+// serve drives the connection for its whole lifetime: it is run in its own
+// goroutine by Server.Serve. A panic anywhere below it (most likely in a
+// Backend/Session implementation) is recovered so one bad connection
+// can't take the whole listener down.
+func (c *Conn) serve() {
+	defer c.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			c.server.logErrorf("smtp: panic serving %v: %v\n%s", c.conn.RemoteAddr(), r, debug.Stack())
+		}
+	}()
+
+	c.greet()
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			if err == ErrTooLongLine {
+				c.Write("500", "5.5.6 Line too long")
+				c.nbrErrors++
+				if c.nbrErrors > 3 {
+					c.Write("500", "Too many errors")
+					return
+				}
+				c.drainLine()
+				continue
+			}
+			return
+		}
+
+		cmd, arg := parseCmd(line)
+		c.handle(cmd, arg)
+	}
+}
+
+// drainLine discards the rest of an over-long line so the next readLine
+// starts realigned on the next command instead of reading mid-line.
+func (c *Conn) drainLine() {
+	for {
+		_, err := c.reader.ReadString('\n')
+		if err == nil {
+			return
+		}
+		if err != ErrTooLongLine {
+			return
+		}
+	}
+}
+
+// drainData discards whatever is left of a DATA body after handleData
+// stops reading it early, so the connection resyncs on the terminating
+// ".\r\n" line instead of having the rest of the message body misread as
+// SMTP commands.
+func (c *Conn) drainData() {
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return
+		}
+	}
+}
+
 // GREET state -> waiting for HELO
 func (c *Conn) handleGreet(enhanced bool, arg string) {
 	if !enhanced {
@@ -158,14 +262,21 @@ func (c *Conn) handleMail(arg string) {
 		c.Write("502", "Please introduce yourself first.")
 		return
 	}
-	if c.msg == nil {
-		c.Write("502", "Please authenticate first.")
-		return
+
+	if c.session == nil {
+		session, err := c.server.bkd.AnonymousLogin(c.state())
+		if err != nil {
+			c.writeSMTPError(toSMTPError(err, 502, EnhancedCode{5, 7, 0}, "Please authenticate first: "))
+			return
+		}
+
+		c.session = session
+		c.msg = &Message{}
 	}
 
 	// Match FROM, while accepting '>' as quoted pair and in double quoted strings
 	// (?i) makes the regex case insensitive, (?:) is non-grouping sub-match
-	re := regexp.MustCompile("(?i)^FROM:\\s*<((?:\\\\>|[^>])+|\"[^\"]+\"@[^>]+)>( [\\w= ]+)?$")
+	re := regexp.MustCompile("(?i)^FROM:\\s*<((?:\\\\>|[^>])+|\"[^\"]+\"@[^>]+)>( .+)?$")
 	m := re.FindStringSubmatch(arg)
 	if m == nil {
 		c.Write("501", "Was expecting MAIL arg syntax of FROM:<address>")
@@ -174,8 +285,7 @@ func (c *Conn) handleMail(arg string) {
 
 	from := m[1]
 
-	// This is where the Conn may put BODY=8BITMIME, but we already
-	// read the DATA as bytes, so it does not effect our processing.
+	opts := MailOptions{}
 	if m[2] != "" {
 		args, err := parseArgs(m[2])
 		if err != nil {
@@ -194,10 +304,60 @@ func (c *Conn) handleMail(arg string) {
 				c.Write("552", "Max message size exceeded")
 				return
 			}
+
+			opts.Size = int(size)
+		}
+
+		opts.Body = strings.ToUpper(args["BODY"])
+		c.bodyType = opts.Body
+
+		if _, ok := args["SMTPUTF8"]; ok {
+			opts.UTF8 = true
+		}
+
+		if ret, ok := args["RET"]; ok {
+			ret = strings.ToUpper(ret)
+			if ret != "FULL" && ret != "HDRS" {
+				c.Write("501", "Invalid RET parameter")
+				return
+			}
+			opts.Ret = ret
+		}
+
+		if envid, ok := args["ENVID"]; ok {
+			decoded, err := decodeXtext(envid)
+			if err != nil {
+				c.Write("501", "Invalid ENVID parameter")
+				return
+			}
+			opts.Envid = decoded
+		}
+
+		if auth, ok := args["AUTH"]; ok {
+			decoded, err := decodeXtext(auth)
+			if err != nil {
+				c.Write("501", "Invalid AUTH parameter")
+				return
+			}
+			opts.Auth = decoded
 		}
 	}
 
+	if !opts.UTF8 && !isASCII(from) {
+		c.Write("553", "5.6.7 SMTPUTF8 required for this address")
+		return
+	}
+	c.smtputf8 = opts.UTF8
+
+	if err := c.session.Mail(from, opts); err != nil {
+		c.writeSMTPError(toSMTPError(err, 451, EnhancedCode{4, 0, 0}, "Error: transaction failed: "))
+		return
+	}
+
 	c.msg.From = from
+	if opts.Ret != "" || opts.Envid != "" {
+		c.msg.DSN = &DSNParams{Ret: opts.Ret, Envid: opts.Envid}
+	}
 	c.Write("250", fmt.Sprintf("Roger, accepting mail from <%v>", from))
 }
 
@@ -208,20 +368,71 @@ func (c *Conn) handleRcpt(arg string) {
 		return
 	}
 
-	if (len(arg) < 4) || (strings.ToUpper(arg[0:3]) != "TO:") {
+	re := regexp.MustCompile("(?i)^TO:\\s*<((?:\\\\>|[^>])+|\"[^\"]+\"@[^>]+)>( .+)?$")
+	m := re.FindStringSubmatch(arg)
+	if m == nil {
 		c.Write("501", "Was expecting RCPT arg syntax of TO:<address>")
 		return
 	}
 
-	// TODO: This trim is probably too forgiving
-	recipient := strings.Trim(arg[3:], "<> ")
+	recipient := m[1]
+
+	if !c.smtputf8 && !isASCII(recipient) {
+		c.Write("553", "5.6.7 SMTPUTF8 required for this address")
+		return
+	}
+
+	opts := RcptOptions{}
+	if m[2] != "" {
+		args, err := parseArgs(m[2])
+		if err != nil {
+			c.Write("501", "Unable to parse RCPT ESMTP parameters")
+			return
+		}
+
+		if notify, ok := args["NOTIFY"]; ok {
+			opts.Notify = strings.Split(strings.ToUpper(notify), ",")
+			hasNever := false
+			for _, v := range opts.Notify {
+				if v != "NEVER" && v != "SUCCESS" && v != "FAILURE" && v != "DELAY" {
+					c.Write("501", "Invalid NOTIFY parameter")
+					return
+				}
+				hasNever = hasNever || v == "NEVER"
+			}
+			if hasNever && len(opts.Notify) > 1 {
+				c.Write("501", "NOTIFY=NEVER cannot be combined with other values")
+				return
+			}
+		}
+
+		if orcpt, ok := args["ORCPT"]; ok {
+			addrType, addr, found := strings.Cut(orcpt, ";")
+			if !found || addrType == "" {
+				c.Write("501", "Invalid ORCPT parameter")
+				return
+			}
+			decoded, err := decodeXtext(addr)
+			if err != nil {
+				c.Write("501", "Invalid ORCPT parameter")
+				return
+			}
+			opts.Orcpt = decoded
+		}
+	}
 
 	if c.server.MaxRecipients > 0 && len(c.msg.To) >= c.server.MaxRecipients {
 		c.Write("552", fmt.Sprintf("Maximum limit of %v recipients reached", c.server.MaxRecipients))
 		return
 	}
 
+	if err := c.session.Rcpt(recipient); err != nil {
+		c.writeSMTPError(toSMTPError(err, 451, EnhancedCode{4, 0, 0}, "Error: transaction failed: "))
+		return
+	}
+
 	c.msg.To = append(c.msg.To, recipient)
+	c.msg.RcptOpts = append(c.msg.RcptOpts, opts)
 	c.Write("250", fmt.Sprintf("I'll make sure <%v> gets this", recipient))
 }
 
@@ -255,14 +466,18 @@ func (c *Conn) handleAuth(arg string) {
 		return
 	}
 
-	sasl := newSasl(c)
+	saslServer := newSasl(c)
 	scanner := bufio.NewScanner(c.reader)
 
 	response := ir
 	for {
-		challenge, done, err := sasl.Next(response)
+		challenge, done, err := saslServer.Next(response)
 		if err != nil {
-			c.Write("454", err.Error())
+			if serr, ok := err.(*SMTPError); ok {
+				c.writeSMTPError(serr)
+			} else {
+				c.Write("454", "4.7.0 "+err.Error())
+			}
 			return
 		}
 
@@ -290,7 +505,7 @@ func (c *Conn) handleAuth(arg string) {
 		}
 	}
 
-	if c.User != nil {
+	if c.session != nil {
 		c.Write("235", "Authentication succeeded")
 
 		c.msg = &Message{}
@@ -337,16 +552,36 @@ func (c *Conn) handleData(arg string) {
 		return
 	}
 
+	if c.bodyType == "BINARYMIME" {
+		c.Write("503", "BINARYMIME must be sent using BDAT, not DATA")
+		return
+	}
+
 	// We have recipients, go to accept data
 	c.Write("354", "Go ahead. End your data with <CR><LF>.<CR><LF>")
 
-	c.msg.Data = newDataReader(c)
-	if err := c.User.Send(c.msg); err != nil {
-		if err, ok := err.(*smtpError); ok {
-			c.Write(err.Code, err.Message)
-		} else {
-			c.Write("554", "Error: transaction failed, blame it on the weather: "+err.Error())
-		}
+	recipients := c.msg.To
+
+	// The line-length limit is meant for SMTP commands, not message
+	// bodies: a body line can legitimately run past it (8BITMIME, long
+	// unfolded headers, ...), and the body is already bounded by
+	// Server.MaxMessageBytes.
+	c.limiter.suspend()
+	r := newDataReader(c)
+	err := c.session.Data(r)
+	if !r.done {
+		// Session.Data (or dataReader itself, e.g. on MaxMessageBytes)
+		// returned before reaching the terminating ".\r\n": the rest of
+		// the body is still sitting on the wire. Discard it so it isn't
+		// misread as SMTP commands.
+		c.drainData()
+	}
+	c.limiter.resume()
+
+	if c.server.LMTP {
+		c.writeStatuses(recipients, err)
+	} else if err != nil {
+		c.writeSMTPError(toSMTPError(err, 554, EnhancedCode{5, 0, 0}, "Error: transaction failed, blame it on the weather: "))
 	} else {
 		c.Write("250", "Ok: queued")
 	}
@@ -384,6 +619,21 @@ func (c *Conn) Write(code string, text ...string) {
 	c.writer.Flush()
 }
 
+// writeSMTPError writes an SMTPError reply, prefixing every line of the
+// message with its RFC 3463 enhanced code when one was set, as required
+// by RFC 2034 for multiline replies.
+func (c *Conn) writeSMTPError(err *SMTPError) {
+	lines := strings.Split(err.Message, "\n")
+	if err.EnhancedCode != (EnhancedCode{}) {
+		ec := err.EnhancedCode
+		for i, line := range lines {
+			lines[i] = fmt.Sprintf("%d.%d.%d %s", ec[0], ec[1], ec[2], line)
+		}
+	}
+
+	c.Write(strconv.Itoa(err.Code), lines...)
+}
+
 // Reads a line of input
 func (c *Conn) readLine() (line string, err error) {
 	if err = c.conn.SetReadDeadline(c.nextDeadline()); err != nil {
@@ -399,11 +649,15 @@ func (c *Conn) readLine() (line string, err error) {
 }
 
 func (c *Conn) reset() {
-	if c.User != nil {
-		c.User.Logout()
+	c.abortChunk(io.ErrClosedPipe)
+
+	if c.session != nil {
+		c.session.Logout()
 	}
 
 	c.helo = ""
-	c.User = nil
+	c.session = nil
 	c.msg = nil
+	c.bodyType = ""
+	c.smtputf8 = false
 }
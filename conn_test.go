@@ -0,0 +1,104 @@
+package smtp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type dsnTestSession struct {
+	from string
+	opts MailOptions
+	to   []string
+}
+
+func (s *dsnTestSession) Mail(from string, opts MailOptions) error {
+	s.from = from
+	s.opts = opts
+	return nil
+}
+
+func (s *dsnTestSession) Rcpt(to string) error {
+	s.to = append(s.to, to)
+	return nil
+}
+
+func (s *dsnTestSession) Data(r io.Reader) error { return nil }
+func (s *dsnTestSession) Reset()                 {}
+func (s *dsnTestSession) Logout() error          { return nil }
+
+type dsnTestBackend struct {
+	session *dsnTestSession
+}
+
+func (b *dsnTestBackend) Login(username, password string) (Session, error) {
+	return nil, &SMTPError{Code: 535, Message: "no login"}
+}
+
+func (b *dsnTestBackend) AnonymousLogin(state *ConnectionState) (Session, error) {
+	b.session = &dsnTestSession{}
+	return b.session, nil
+}
+
+// TestDSNAndSMTPUTF8 round-trips a Japanese MAIL FROM local-part under
+// SMTPUTF8 and an ORCPT=rfc822;<xtext> on RCPT TO.
+func TestDSNAndSMTPUTF8(t *testing.T) {
+	bkd := &dsnTestBackend{}
+	srv := New(bkd)
+	srv.Domain = "localhost"
+	srv.AllowInsecureAuth = true
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn := newConn(server, srv)
+	go conn.serve()
+
+	br := bufio.NewReader(client)
+	readReply := func() string {
+		var last string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading reply: %v", err)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			last = line
+			if len(line) < 4 || line[3] == '-' {
+				continue
+			}
+			return last
+		}
+	}
+
+	readReply() // 220 greeting
+
+	io.WriteString(client, "EHLO example.org\r\n")
+	readReply()
+
+	io.WriteString(client, "MAIL FROM:<日本語@example.com> SMTPUTF8 ENVID=+E2+88+9A\r\n")
+	if reply := readReply(); !strings.HasPrefix(reply, "250") {
+		t.Fatalf("MAIL FROM failed: %v", reply)
+	}
+
+	io.WriteString(client, "RCPT TO:<bob@example.com> ORCPT=rfc822;user+40example.com\r\n")
+	if reply := readReply(); !strings.HasPrefix(reply, "250") {
+		t.Fatalf("RCPT TO failed: %v", reply)
+	}
+
+	if bkd.session.from != "日本語@example.com" {
+		t.Errorf("From = %q, want Japanese local-part preserved", bkd.session.from)
+	}
+	if !bkd.session.opts.UTF8 {
+		t.Errorf("MailOptions.UTF8 = false, want true")
+	}
+	if bkd.session.opts.Envid != "√" {
+		t.Errorf("MailOptions.Envid = %q, want decoded xtext", bkd.session.opts.Envid)
+	}
+
+	if len(conn.msg.RcptOpts) != 1 || conn.msg.RcptOpts[0].Orcpt != "user@example.com" {
+		t.Errorf("RcptOpts = %+v, want Orcpt \"user@example.com\"", conn.msg.RcptOpts)
+	}
+}
@@ -0,0 +1,20 @@
+package smtp
+
+import "net"
+
+// ConnectionState describes a connection to Backend.AnonymousLogin, so a
+// Backend can decide whether to accept mail without authentication based
+// on where it's coming from and how it introduced itself.
+type ConnectionState struct {
+	RemoteAddr net.Addr
+	Hostname   string
+	TLS        bool
+}
+
+func (c *Conn) state() *ConnectionState {
+	return &ConnectionState{
+		RemoteAddr: c.conn.RemoteAddr(),
+		Hostname:   c.helo,
+		TLS:        c.IsTLS(),
+	}
+}
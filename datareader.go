@@ -0,0 +1,59 @@
+package smtp
+
+import "io"
+
+// dataReader reads the body of a DATA command off the wire, undoing dot
+// stuffing and stopping at the terminating "<CR><LF>.<CR><LF>" line.
+type dataReader struct {
+	c        *Conn
+	read     int
+	leftover []byte
+	done     bool
+}
+
+func newDataReader(c *Conn) *dataReader {
+	return &dataReader{c: c}
+}
+
+func (r *dataReader) Read(b []byte) (n int, err error) {
+	for n < len(b) {
+		if len(r.leftover) > 0 {
+			copied := copy(b[n:], r.leftover)
+			n += copied
+			r.leftover = r.leftover[copied:]
+			continue
+		}
+
+		if r.done {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+
+		line, err := r.c.readLine()
+		if err != nil {
+			return n, err
+		}
+
+		if line == ".\r\n" || line == ".\n" {
+			r.done = true
+			continue
+		}
+
+		// Undo dot-stuffing: a leading dot on a line is doubled by the
+		// client so a real end-of-data marker can't be confused with it.
+		if len(line) > 0 && line[0] == '.' {
+			line = line[1:]
+		}
+
+		r.read += len(line)
+		if r.c.server.MaxMessageBytes > 0 && r.read > r.c.server.MaxMessageBytes {
+			return n, &SMTPError{Code: 552, EnhancedCode: EnhancedCode{5, 3, 4}, Message: "Message too big"}
+		}
+
+		r.leftover = []byte(line)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,33 @@
+package smtp
+
+import "fmt"
+
+// EnhancedCode is a RFC 3463 enhanced status code, e.g. {5, 1, 1} for
+// "bad destination mailbox address". A zero value means none was given.
+type EnhancedCode [3]int
+
+// SMTPError is the error type Session hooks should return to control
+// exactly what status code, enhanced code and text is written back to
+// the client, instead of the generic code Conn falls back to for a plain
+// error (451 for Mail and Rcpt, 554 for Data).
+type SMTPError struct {
+	Code         int
+	EnhancedCode EnhancedCode
+	Message      string
+}
+
+func (err *SMTPError) Error() string {
+	return fmt.Sprintf("SMTP error %v: %v", err.Code, err.Message)
+}
+
+// toSMTPError normalizes any error returned by a Session hook into an
+// *SMTPError, falling back to code for anything that isn't already one.
+func toSMTPError(err error, code int, enhanced EnhancedCode, fallbackMessage string) *SMTPError {
+	if err == nil {
+		return nil
+	}
+	if serr, ok := err.(*SMTPError); ok {
+		return serr
+	}
+	return &SMTPError{Code: code, EnhancedCode: enhanced, Message: fallbackMessage + err.Error()}
+}
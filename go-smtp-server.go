@@ -4,6 +4,7 @@ package go-smtp-server
 
 import (
     "errors"
+    "io"
     "io/ioutil"
     "log"
 
@@ -12,19 +13,31 @@ import (
 
 type Backend struct{}
 
-func (bkd *Backend) Login(username, password string) (smtpserver.User, error) {
+func (bkd *Backend) Login(username, password string) (smtpserver.Session, error) {
     if username != "username" || password != "password" {
         return nil, errors.New("Invalid username or password")
     }
-    return &User{}, nil
+    return &Session{}, nil
 }
 
-type User struct{}
+func (bkd *Backend) AnonymousLogin(state *smtpserver.ConnectionState) (smtpserver.Session, error) {
+    return nil, errors.New("Anonymous mail not accepted")
+}
 
-func (u *User) Send(msg *smtpserver.Message) error {
-    log.Println("Sending message:", msg)
+type Session struct{}
 
-    if b, err := ioutil.ReadAll(msg.Data); err != nil {
+func (s *Session) Mail(from string, opts smtpserver.MailOptions) error {
+    log.Println("Mail from:", from)
+    return nil
+}
+
+func (s *Session) Rcpt(to string) error {
+    log.Println("Rcpt to:", to)
+    return nil
+}
+
+func (s *Session) Data(r io.Reader) error {
+    if b, err := ioutil.ReadAll(r); err != nil {
         return err
     } else {
         log.Println("Data:", string(b))
@@ -32,7 +45,9 @@ func (u *User) Send(msg *smtpserver.Message) error {
     return nil
 }
 
-func (u *User) Logout() error {
+func (s *Session) Reset() {}
+
+func (s *Session) Logout() error {
     return nil
 }
 
@@ -52,4 +67,4 @@ func main() {
     if err := s.ListenAndServe(); err != nil {
         log.Fatal(err)
     }
-}
\ No newline at end of file
+}
@@ -0,0 +1,68 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTooLongLine is surfaced by Conn.readLine when a client sends a
+// command line longer than the configured limit without ever
+// reaching a newline, so a hostile client can't OOM the process by
+// streaming an unbounded line.
+var ErrTooLongLine = errors.New("smtp: line too long")
+
+// defaultMaxLineLength is RFC 5321 section 4.5.3.1.6's minimum line
+// length a server must accept, used whenever Server.MaxLineLength isn't
+// set.
+const defaultMaxLineLength = 4000
+
+// lineLimitReader sits between the raw connection and the bufio.Reader
+// that does line splitting, counting bytes since the last '\n' and
+// failing once that count passes maxLine. The limit only makes sense for
+// SMTP commands, which are meant to be short lines; it is suspended
+// around DATA/BDAT message bodies, which are read for their own byte
+// count (or line-terminated text that legitimately runs past maxLine)
+// and are instead bounded by Server.MaxMessageBytes.
+type lineLimitReader struct {
+	r         io.Reader
+	maxLine   int
+	cur       int
+	suspended bool
+}
+
+// suspend disables the line-length check until resume is called.
+func (lr *lineLimitReader) suspend() {
+	lr.suspended = true
+}
+
+// resume re-enables the line-length check, starting a fresh line.
+func (lr *lineLimitReader) resume() {
+	lr.suspended = false
+	lr.cur = 0
+}
+
+func (lr *lineLimitReader) Read(b []byte) (int, error) {
+	n, err := lr.r.Read(b)
+
+	if lr.suspended {
+		return n, err
+	}
+
+	for i := 0; i < n; i++ {
+		if b[i] == '\n' {
+			lr.cur = 0
+			continue
+		}
+
+		lr.cur++
+		if lr.cur > lr.maxLine {
+			// Surface every byte already read from the socket, not just
+			// up to the offending one: bufio buffers n and err together,
+			// so nothing pipelined after the overlong line (including its
+			// own terminating '\n') is lost.
+			return n, ErrTooLongLine
+		}
+	}
+
+	return n, err
+}
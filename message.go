@@ -0,0 +1,16 @@
+package smtp
+
+// Message tracks the envelope of the transaction currently in progress:
+// the sender and recipients a Session has already accepted via Mail and
+// Rcpt. The body itself is streamed straight to Session.Data rather than
+// buffered here.
+type Message struct {
+	From string
+	To   []string
+
+	// DSN is set when MAIL FROM carried RET= and/or ENVID=.
+	DSN *DSNParams
+	// RcptOpts holds the ESMTP parameters of each RCPT TO in To, in the
+	// same order.
+	RcptOpts []RcptOptions
+}
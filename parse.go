@@ -0,0 +1,52 @@
+package smtp
+
+import (
+	"errors"
+	"strings"
+)
+
+// parseHelloArgument validates the single domain/address-literal argument
+// required by HELO/EHLO (and LHLO).
+func parseHelloArgument(arg string) (string, error) {
+	domain := arg
+	if idx := strings.IndexRune(domain, ' '); idx >= 0 {
+		domain = domain[:idx]
+	}
+	if domain == "" {
+		return "", errors.New("Invalid domain")
+	}
+	return domain, nil
+}
+
+// parseCmd splits a line read off the wire into its command verb and the
+// rest of the line, e.g. "MAIL FROM:<a@b>" -> ("MAIL", "FROM:<a@b>").
+func parseCmd(line string) (cmd string, arg string) {
+	line = strings.TrimRight(line, "\r\n")
+
+	parts := strings.SplitN(line, " ", 2)
+	cmd = strings.ToUpper(strings.TrimSpace(parts[0]))
+	if len(parts) > 1 {
+		arg = strings.TrimSpace(parts[1])
+	}
+
+	return cmd, arg
+}
+
+// parseArgs parses the trailing ESMTP parameter list of a MAIL or RCPT
+// command, e.g. " SIZE=1024 BODY=8BITMIME", into a map keyed by the
+// upper-cased parameter name.
+func parseArgs(s string) (map[string]string, error) {
+	args := map[string]string{}
+
+	for _, arg := range strings.Fields(s) {
+		m := strings.SplitN(arg, "=", 2)
+		key := strings.ToUpper(m[0])
+		value := ""
+		if len(m) > 1 {
+			value = m[1]
+		}
+		args[key] = value
+	}
+
+	return args, nil
+}
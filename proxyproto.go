@@ -0,0 +1,179 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadProxyHeader is returned when a peer in Server.TrustedProxies opens
+// a connection that doesn't start with a well-formed PROXY protocol
+// header.
+var ErrBadProxyHeader = errors.New("smtp: malformed PROXY protocol header")
+
+// proxyHeaderTimeout bounds how long applyProxyProtocol will wait for the
+// header, so a trusted-but-stuck peer can't stall the greeting forever.
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxiedConn wraps a net.Conn to report the real client address learned
+// from a PROXY protocol header instead of the address of the proxy itself.
+type proxiedConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// isTrustedProxy reports whether addr's IP is covered by one of the
+// server's configured TrustedProxies.
+func (s *Server) isTrustedProxy(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range s.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyProxyProtocol is a no-op unless EnableProxyProtocol is set and the
+// immediate peer is trusted. Otherwise it peeks the start of the
+// connection for a PROXY protocol v1 or v2 header and, on success,
+// replaces c.conn with a proxiedConn reporting the real client address.
+// A malformed header from a trusted peer is a hard error: the caller
+// closes the connection before ever writing the 220 banner.
+func (c *Conn) applyProxyProtocol() error {
+	if !c.server.EnableProxyProtocol || !c.server.isTrustedProxy(c.conn.RemoteAddr()) {
+		return nil
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	prefix, err := c.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return ErrBadProxyHeader
+	}
+
+	var remote net.Addr
+	if string(prefix) == string(proxyV2Signature) {
+		remote, err = c.readProxyV2()
+	} else {
+		remote, err = c.readProxyV1()
+	}
+	if err != nil {
+		return err
+	}
+
+	c.conn = &proxiedConn{Conn: c.conn, remoteAddr: remote}
+	return nil
+}
+
+// readProxyV1 parses the PROXY protocol v1 ASCII header, e.g.
+// "PROXY TCP4 192.0.2.1 192.0.2.2 51234 25\r\n".
+func (c *Conn) readProxyV1() (net.Addr, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	fields := strings.Fields(strings.TrimRight(line, "\r\n"))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrBadProxyHeader
+	}
+
+	if fields[1] == "UNKNOWN" {
+		return c.conn.RemoteAddr(), nil
+	}
+
+	if len(fields) != 6 {
+		return nil, ErrBadProxyHeader
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	if srcIP == nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, nil
+}
+
+// readProxyV2 parses the PROXY protocol v2 binary header: a 12-byte
+// signature (already matched by the caller), a version/command byte, an
+// address-family/protocol byte, a 2-byte big-endian address block length,
+// and the address block itself.
+func (c *Conn) readProxyV2() (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header[:13]); err != nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, ErrBadProxyHeader
+	}
+	cmd := verCmd & 0x0F
+
+	famProto, err := c.reader.ReadByte()
+	if err != nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	lenBytes := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, lenBytes); err != nil {
+		return nil, ErrBadProxyHeader
+	}
+	addrLen := int(lenBytes[0])<<8 | int(lenBytes[1])
+
+	addrBlock := make([]byte, addrLen)
+	if _, err := io.ReadFull(c.reader, addrBlock); err != nil {
+		return nil, ErrBadProxyHeader
+	}
+
+	if cmd == 0x0 {
+		// LOCAL command: a health check from the proxy itself, not a
+		// proxied client. Keep reporting the proxy's own address.
+		return c.conn.RemoteAddr(), nil
+	}
+
+	switch famProto >> 4 {
+	case 0x1: // AF_INET
+		if addrLen < 12 {
+			return nil, ErrBadProxyHeader
+		}
+		ip := net.IP(addrBlock[0:4])
+		port := int(addrBlock[8])<<8 | int(addrBlock[9])
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	case 0x2: // AF_INET6
+		if addrLen < 36 {
+			return nil, ErrBadProxyHeader
+		}
+		ip := net.IP(addrBlock[0:16])
+		port := int(addrBlock[32])<<8 | int(addrBlock[33])
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	default:
+		return nil, ErrBadProxyHeader
+	}
+}
@@ -0,0 +1,11 @@
+package smtp
+
+// SaslServer is implemented by a server-side SASL mechanism bound to a
+// single connection. Next is fed the client's most recent response (nil
+// on the first call unless the client sent an initial response) and
+// returns the next challenge to send, or done once authentication has
+// concluded. err, if set, ends the exchange; an *SMTPError controls the
+// exact reply, anything else is reported as a generic failure.
+type SaslServer interface {
+	Next(response []byte) (challenge []byte, done bool, err error)
+}
@@ -0,0 +1,37 @@
+package smtp
+
+// loginServer implements the (non-standard, but near-universally
+// supported) SASL LOGIN mechanism: the server prompts for a username
+// then a password, each base64-encoded in turn.
+type loginServer struct {
+	conn     *Conn
+	step     int
+	username string
+}
+
+func newLoginServer(conn *Conn) SaslServer {
+	return &loginServer{conn: conn}
+}
+
+func (a *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch a.step {
+	case 0:
+		a.step++
+		return []byte("Username:"), false, nil
+	case 1:
+		a.username = string(response)
+		a.step++
+		return []byte("Password:"), false, nil
+	case 2:
+		a.step++
+		session, err := a.conn.server.bkd.Login(a.username, string(response))
+		if err != nil {
+			return nil, false, authFailed(err.Error())
+		}
+
+		a.conn.session = session
+		return nil, true, nil
+	}
+
+	return nil, false, authFailed("Unexpected LOGIN state")
+}
@@ -0,0 +1,40 @@
+package smtp
+
+import "bytes"
+
+// plainServer implements SASL PLAIN (RFC 4616): a single message of
+// authzid NUL authcid NUL passwd.
+type plainServer struct {
+	conn *Conn
+}
+
+func newPlainServer(conn *Conn) SaslServer {
+	return &plainServer{conn: conn}
+}
+
+func (a *plainServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	parts := bytes.SplitN(response, []byte{0}, 3)
+	if len(parts) != 3 {
+		return nil, false, authFailed("Invalid PLAIN response")
+	}
+
+	username, password := string(parts[1]), string(parts[2])
+
+	session, err := a.conn.server.bkd.Login(username, password)
+	if err != nil {
+		return nil, false, authFailed(err.Error())
+	}
+
+	a.conn.session = session
+	return nil, true, nil
+}
+
+// authFailed wraps message as the reply RFC 4954 expects for a rejected AUTH
+// attempt: 535 5.7.8.
+func authFailed(message string) *SMTPError {
+	return &SMTPError{Code: 535, EnhancedCode: EnhancedCode{5, 7, 8}, Message: message}
+}
@@ -0,0 +1,50 @@
+package smtp
+
+import "strings"
+
+// xoauth2Server implements the (draft) SASL XOAUTH2 mechanism: a single
+// message of "user=<user>\x01auth=Bearer <token>\x01\x01". On failure the
+// server must send one more 334 challenge carrying a base64 JSON error
+// blob before it can fail the exchange with 535, per the draft spec.
+type xoauth2Server struct {
+	conn   *Conn
+	failed bool
+}
+
+func newXoauth2Server(conn *Conn) SaslServer {
+	return &xoauth2Server{conn: conn}
+}
+
+func (a *xoauth2Server) Next(response []byte) (challenge []byte, done bool, err error) {
+	if a.failed {
+		return nil, false, authFailed("Authentication failed")
+	}
+
+	username, token := parseXoauth2(string(response))
+
+	session, err := a.conn.server.bkd.Login(username, token)
+	if err != nil {
+		a.failed = true
+		blob := `{"status":"401","schemes":"bearer","scope":""}`
+		return []byte(blob), false, nil
+	}
+
+	a.conn.session = session
+	return nil, true, nil
+}
+
+func parseXoauth2(msg string) (username, token string) {
+	for _, field := range strings.Split(msg, "\x01") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "user":
+			username = kv[1]
+		case "auth":
+			token = strings.TrimPrefix(kv[1], "Bearer ")
+		}
+	}
+	return username, token
+}
@@ -0,0 +1,119 @@
+package smtp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+)
+
+// Server is an SMTP server.
+type Server struct {
+	Addr            string
+	Domain          string
+	MaxIdleSeconds  int
+	MaxMessageBytes int
+	MaxRecipients   int
+	// MaxLineLength caps how many bytes of a single line Conn.readLine
+	// will accept before failing with ErrTooLongLine. Defaults to
+	// defaultMaxLineLength when 0.
+	MaxLineLength     int
+	AllowInsecureAuth bool
+	TLSConfig         *tls.Config
+	Debug             io.Writer
+	ErrorLog          *log.Logger
+
+	// LMTP switches the dispatcher from SMTP (RFC 5321) to LMTP (RFC 2033):
+	// the greeting verb becomes LHLO and DATA replies with one status line
+	// per recipient instead of a single aggregate 250.
+	LMTP bool
+
+	// EnableProxyProtocol, when true, makes connections from a peer listed
+	// in TrustedProxies start with a HAProxy PROXY protocol v1/v2 header
+	// carrying the real client address, as set up by a reverse proxy.
+	EnableProxyProtocol bool
+	TrustedProxies      []*net.IPNet
+
+	bkd   Backend
+	caps  []string
+	auths map[string]func(*Conn) SaslServer
+
+	listener net.Listener
+}
+
+// New creates a new Server backed by bkd. Callers should set the exported
+// fields they care about (Addr, Domain, ...) before calling ListenAndServe.
+//
+// PLAIN, LOGIN, CRAM-MD5, SCRAM-SHA-1, SCRAM-SHA-256 and XOAUTH2 are
+// registered out of the box; call EnableAuth to add more or to replace
+// one of these.
+func New(bkd Backend) *Server {
+	s := &Server{
+		bkd:   bkd,
+		caps:  []string{"PIPELINING", "8BITMIME", "CHUNKING", "BINARYMIME", "DSN", "SMTPUTF8"},
+		auths: map[string]func(*Conn) SaslServer{},
+	}
+
+	s.EnableAuth("PLAIN", newPlainServer)
+	s.EnableAuth("LOGIN", newLoginServer)
+	s.EnableAuth("CRAM-MD5", newCramMD5Server)
+	s.EnableAuth("SCRAM-SHA-1", newScramServer("SCRAM-SHA-1", sha1.New))
+	s.EnableAuth("SCRAM-SHA-256", newScramServer("SCRAM-SHA-256", sha256.New))
+	s.EnableAuth("XOAUTH2", newXoauth2Server)
+
+	return s
+}
+
+// EnableAuth registers a SASL mechanism under name, so it's offered in
+// the EHLO AUTH capability (subject to IsTLS/AllowInsecureAuth) and
+// accepted by the AUTH command. Calling it again with an existing name
+// replaces that mechanism.
+func (s *Server) EnableAuth(name string, factory func(*Conn) SaslServer) {
+	s.auths[name] = factory
+}
+
+// ListenAndServe listens on s.Addr and then calls Serve to handle incoming
+// connections.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and handles each one in its own goroutine
+// until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.listener = l
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		c := newConn(conn, s)
+		if c == nil {
+			// A trusted proxy sent a malformed PROXY protocol header; the
+			// connection was already closed without a banner.
+			continue
+		}
+
+		go c.serve()
+	}
+}
+
+// Close stops the server from accepting new connections.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) logErrorf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	}
+}
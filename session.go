@@ -0,0 +1,60 @@
+package smtp
+
+import "io"
+
+// Session is a mail transaction bound to an authenticated (or anonymous)
+// connection. Returning an *SMTPError from any hook rejects the envelope
+// at that point instead of only at Data, e.g. a host can refuse a
+// sender or recipient before ever accepting message bytes.
+type Session interface {
+	Mail(from string, opts MailOptions) error
+	Rcpt(to string) error
+	Data(r io.Reader) error
+	Reset()
+	Logout() error
+}
+
+// MailOptions carries the ESMTP parameters a client attached to MAIL FROM,
+// beyond the bare address. Per-recipient parameters are in RcptOptions.
+type MailOptions struct {
+	// Size is the SIZE= value the client announced, in octets, or 0 if
+	// it didn't send one.
+	Size int
+
+	// Body is BODY= as sent by the client: "", "7BIT", "8BITMIME" or
+	// "BINARYMIME".
+	Body string
+
+	// UTF8 is true if the client sent SMTPUTF8.
+	UTF8 bool
+
+	// Auth is AUTH= as sent by the client (RFC 2034/4954 submission
+	// identity), xtext-decoded, or "" if it wasn't sent.
+	Auth string
+
+	// Ret is RET= as sent by the client for DSN: "FULL", "HDRS" or "".
+	Ret string
+
+	// Envid is ENVID= as sent by the client for DSN, xtext-decoded, or
+	// "" if it wasn't sent.
+	Envid string
+}
+
+// DSNParams is the subset of MailOptions that describes a DSN (RFC 3461)
+// request, kept on Message once MAIL has been accepted.
+type DSNParams struct {
+	Ret   string
+	Envid string
+}
+
+// RcptOptions carries the ESMTP parameters a client attached to a single
+// RCPT TO, beyond the bare address.
+type RcptOptions struct {
+	// Notify is NOTIFY= as sent by the client for DSN, e.g.
+	// []string{"SUCCESS", "FAILURE"}, or nil if it wasn't sent.
+	Notify []string
+
+	// Orcpt is the address half of ORCPT= as sent by the client for DSN,
+	// xtext-decoded, or "" if it wasn't sent.
+	Orcpt string
+}
@@ -0,0 +1,53 @@
+package smtp
+
+import "fmt"
+
+// statusCollector gathers the reply to send for each recipient of an LMTP
+// DATA/BDAT transaction, since RFC 2033 requires one status line per RCPT
+// TO rather than a single aggregate reply.
+//
+// Session.Data currently reports a single error for the whole message, so
+// every recipient gets the same status; once Session grows a way to
+// report per-recipient outcomes, fill can be taught to record them
+// individually without changing callers.
+type statusCollector struct {
+	recipients []string
+	results    []*SMTPError
+}
+
+func newStatusCollector(recipients []string) *statusCollector {
+	return &statusCollector{recipients: recipients}
+}
+
+func (sc *statusCollector) fill(err error) {
+	sc.results = make([]*SMTPError, len(sc.recipients))
+	for i := range sc.recipients {
+		if err == nil {
+			sc.results[i] = nil
+			continue
+		}
+		sc.results[i] = toSMTPError(err, 554, EnhancedCode{5, 0, 0}, "Error: transaction failed, blame it on the weather: ")
+	}
+}
+
+// writeStatuses replies to an LMTP DATA/BDAT command, one line per
+// recipient as required by RFC 2033 section 4.2.
+func (c *Conn) writeStatuses(recipients []string, err error) {
+	sc := newStatusCollector(recipients)
+	sc.fill(err)
+
+	for i, rcpt := range sc.recipients {
+		serr := sc.results[i]
+		if serr == nil {
+			c.Write("250", fmt.Sprintf("<%v> 2.1.5 Delivered", rcpt))
+			continue
+		}
+
+		msg := serr.Message
+		if serr.EnhancedCode != (EnhancedCode{}) {
+			ec := serr.EnhancedCode
+			msg = fmt.Sprintf("%d.%d.%d %s", ec[0], ec[1], ec[2], msg)
+		}
+		c.Write(fmt.Sprintf("%d", serr.Code), fmt.Sprintf("<%v> %v", rcpt, msg))
+	}
+}
@@ -0,0 +1,50 @@
+package smtp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// decodeXtext decodes an RFC 3461 section 4 "xtext" string: printable
+// US-ASCII (33-126) verbatim, except that '+' introduces a 2-digit hex
+// escape for an arbitrary byte, used by DSN's ENVID and ORCPT parameters
+// to carry bytes '<', '>' and non-ASCII text safely inside ESMTP params.
+func decodeXtext(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", errors.New("smtp: invalid xtext character")
+		}
+
+		if c != '+' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+2 >= len(s) {
+			return "", errors.New("smtp: truncated xtext escape")
+		}
+		v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.New("smtp: invalid xtext escape")
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+// isASCII reports whether s is plain 7-bit ASCII, used to decide whether
+// an address requires SMTPUTF8.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return false
+		}
+	}
+	return true
+}